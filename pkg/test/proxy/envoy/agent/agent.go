@@ -16,7 +16,6 @@ package agent
 
 import (
 	"fmt"
-	"net"
 
 	"go.uber.org/multierr"
 
@@ -39,6 +38,12 @@ type Config struct {
 	TLSCKey     string
 	Version     string
 	TmpDir      string
+	// BindAddress is the local address Envoy and the backend service listen on. Must resolve
+	// to a loopback interface (e.g. "localhost", "127.0.0.1", "::1"). Defaults to "localhost".
+	BindAddress string
+	// BootstrapProvider renders the Envoy bootstrap config. Defaults to StaticBootstrapProvider
+	// when nil.
+	BootstrapProvider BootstrapProvider
 }
 
 // Port contains the port mapping for a single configured port
@@ -46,6 +51,7 @@ type Port struct {
 	Config      PortConfig
 	EnvoyPort   int
 	ServicePort int
+	Address     string
 }
 
 // Agent bootstraps a local service/Envoy combination.
@@ -55,17 +61,24 @@ type Agent struct {
 	app            *echo.Server
 	envoyConfig    *envoyConfig
 	envoyAdminPort int
+	envoyReadyPort int
 	ports          []Port
+	reserved       []*ReservedPort
+	bindAddress    string
 }
 
 // Start starts Envoy and the service.
 func (a *Agent) Start() (err error) {
+	if a.bindAddress, err = resolveBindAddress(a.Config.BindAddress); err != nil {
+		return err
+	}
+
 	if err = a.startService(); err != nil {
 		return err
 	}
 
 	// Generate the port mappings between Envoy and the backend service.
-	a.envoyAdminPort, a.ports, err = a.createPorts()
+	a.envoyAdminPort, a.envoyReadyPort, a.ports, err = a.createPorts()
 	if err != nil {
 		return err
 	}
@@ -86,6 +99,10 @@ func (a *Agent) Stop() error {
 		a.envoyConfig.dispose()
 		a.envoyConfig = nil
 	}
+	for _, r := range a.reserved {
+		err = multierr.Append(err, r.Release())
+	}
+	a.reserved = nil
 	return err
 }
 
@@ -99,34 +116,107 @@ func (a *Agent) GetEnvoyAdminPort() int {
 	return a.envoyAdminPort
 }
 
+// GetEnvoyReadyPort returns the dedicated readiness port for Envoy after the Agent has been started.
+func (a *Agent) GetEnvoyReadyPort() int {
+	return a.envoyReadyPort
+}
+
+// DumpBootstrap renders and returns the Envoy bootstrap config that Start would write to disk
+// and hand to Envoy, without actually starting Envoy.
+func (a *Agent) DumpBootstrap() (_ []byte, err error) {
+	if a.bindAddress, err = resolveBindAddress(a.Config.BindAddress); err != nil {
+		return nil, err
+	}
+	if err = a.startService(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = multierr.Append(err, a.app.Stop())
+	}()
+
+	adminPort, readyPort, ports, createErr := a.createPorts()
+	defer func() {
+		for _, r := range a.reserved {
+			err = multierr.Append(err, r.Release())
+		}
+		a.reserved = nil
+	}()
+	if createErr != nil {
+		return nil, createErr
+	}
+
+	return a.bootstrapProvider().Render(BootstrapParams{
+		ServiceName: a.Config.ServiceName,
+		BindAddress: a.bindAddress,
+		AdminPort:   adminPort,
+		ReadyPort:   readyPort,
+		Ports:       ports,
+		TLSCert:     a.Config.TLSCert,
+		TLSCKey:     a.Config.TLSCKey,
+	})
+}
+
+// bootstrapProvider returns the configured BootstrapProvider, falling back to the built-in
+// static Envoy config generation when the caller didn't supply one.
+func (a *Agent) bootstrapProvider() BootstrapProvider {
+	if a.Config.BootstrapProvider != nil {
+		return a.Config.BootstrapProvider
+	}
+	return StaticBootstrapProvider{}
+}
+
 func (a *Agent) startService() error {
-	// TODO(nmittler): Add support for other protocols
+	a.app = &echo.Server{
+		BindAddress: a.bindAddress,
+		TLSCert:     a.Config.TLSCert,
+		TLSCKey:     a.Config.TLSCKey,
+		Version:     a.Config.Version,
+	}
+
+	// Route each configured port into the backend list for its protocol. The index within
+	// each list is filled in once echo.Server.Start() has bound the actual service ports.
 	for _, port := range a.Config.Ports {
 		switch port.Protocol {
 		case model.ProtocolHTTP:
-			// Just verifying that all ports are HTTP for now.
+			a.app.HTTPPorts = append(a.app.HTTPPorts, 0)
+		case model.ProtocolHTTPS:
+			a.app.HTTPSPorts = append(a.app.HTTPSPorts, 0)
+		case model.ProtocolGRPC:
+			a.app.GRPCPorts = append(a.app.GRPCPorts, 0)
+		case model.ProtocolTCP:
+			a.app.TCPPorts = append(a.app.TCPPorts, 0)
 		default:
 			return fmt.Errorf("protocol %v not currently supported", port.Protocol)
 		}
 	}
-
-	a.app = &echo.Server{
-		HTTPPorts: make([]int, len(a.Config.Ports)),
-		TLSCert:   a.Config.TLSCert,
-		TLSCKey:   a.Config.TLSCKey,
-		Version:   a.Config.Version,
-	}
 	return a.app.Start()
 }
 
 func (a *Agent) startEnvoy() (err error) {
-	// Create the configuration object
-	a.envoyConfig, err = (&envoyConfigBuilder{
+	// Render the bootstrap config and write it to disk.
+	rendered, err := a.bootstrapProvider().Render(BootstrapParams{
 		ServiceName: a.Config.ServiceName,
+		BindAddress: a.bindAddress,
 		AdminPort:   a.envoyAdminPort,
+		ReadyPort:   a.envoyReadyPort,
 		Ports:       a.ports,
-		tmpDir:      a.Config.TmpDir,
-	}).build()
+		TLSCert:     a.Config.TLSCert,
+		TLSCKey:     a.Config.TLSCKey,
+	})
+	if err != nil {
+		return err
+	}
+	a.envoyConfig, err = writeBootstrap(a.Config.TmpDir, a.Config.ServiceName, rendered)
+	if err != nil {
+		return err
+	}
+
+	// Only release the reserved ports now, immediately before Envoy binds them, to keep the
+	// listen-close-rebind race window as small as possible.
+	for _, r := range a.reserved {
+		err = multierr.Append(err, r.Release())
+	}
+	a.reserved = nil
 	if err != nil {
 		return err
 	}
@@ -138,39 +228,51 @@ func (a *Agent) startEnvoy() (err error) {
 	return a.e.Start()
 }
 
-func (a *Agent) createPorts() (adminPort int, ports []Port, err error) {
-	if adminPort, err = findFreePort(); err != nil {
+func (a *Agent) createPorts() (adminPort int, readyPort int, ports []Port, err error) {
+	admin, err := portAllocator.Reserve(a.bindAddress)
+	if err != nil {
+		return
+	}
+	a.reserved = append(a.reserved, admin)
+	adminPort = admin.Port
+
+	ready, err := portAllocator.Reserve(a.bindAddress)
+	if err != nil {
 		return
 	}
+	a.reserved = append(a.reserved, ready)
+	readyPort = ready.Port
+
+	// Pop the next service port off the per-protocol list allocated by startService(), in the
+	// same order the protocols were encountered there, so it lines up with a.Config.Ports.
+	var httpIdx, httpsIdx, grpcIdx, tcpIdx int
+	ports = make([]Port, len(a.Config.Ports))
+	for i, portConfig := range a.Config.Ports {
+		var servicePort int
+		switch portConfig.Protocol {
+		case model.ProtocolHTTP:
+			servicePort, httpIdx = a.app.HTTPPorts[httpIdx], httpIdx+1
+		case model.ProtocolHTTPS:
+			servicePort, httpsIdx = a.app.HTTPSPorts[httpsIdx], httpsIdx+1
+		case model.ProtocolGRPC:
+			servicePort, grpcIdx = a.app.GRPCPorts[grpcIdx], grpcIdx+1
+		case model.ProtocolTCP:
+			servicePort, tcpIdx = a.app.TCPPorts[tcpIdx], tcpIdx+1
+		}
 
-	servicePorts := a.app.HTTPPorts
-	ports = make([]Port, len(servicePorts))
-	for i, servicePort := range servicePorts {
-		var envoyPort int
-		envoyPort, err = findFreePort()
+		var envoyPort *ReservedPort
+		envoyPort, err = portAllocator.Reserve(a.bindAddress)
 		if err != nil {
 			return
 		}
+		a.reserved = append(a.reserved, envoyPort)
 
 		ports[i] = Port{
-			Config:      a.Config.Ports[i],
+			Config:      portConfig,
 			ServicePort: servicePort,
-			EnvoyPort:   envoyPort,
+			EnvoyPort:   envoyPort.Port,
+			Address:     a.bindAddress,
 		}
 	}
 	return
 }
-
-func findFreePort() (int, error) {
-	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
-	if err != nil {
-		return 0, err
-	}
-
-	l, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		return 0, err
-	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port, nil
-}
@@ -0,0 +1,172 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"text/template"
+)
+
+// BootstrapParams carries everything a BootstrapProvider needs to render an Envoy bootstrap
+// config for a single Agent.
+type BootstrapParams struct {
+	ServiceName string
+	BindAddress string
+	AdminPort   int
+	ReadyPort   int
+	Ports       []Port
+	// TLSCert and TLSCKey are the certificate/key pair backing any ProtocolHTTPS port. They are
+	// the same files the backend echo service was started with, so Envoy's TLS termination and
+	// the backend stay in sync.
+	TLSCert string
+	TLSCKey string
+}
+
+// BootstrapProvider renders the Envoy bootstrap config for an Agent. Config.BootstrapProvider
+// defaults to StaticBootstrapProvider, but callers can supply their own to hand Envoy a
+// pre-baked template or point it at a real xDS server instead of the built-in static clusters.
+type BootstrapProvider interface {
+	Render(params BootstrapParams) ([]byte, error)
+}
+
+// TemplateBootstrapProvider renders a bootstrap config from a caller-supplied text/template
+// source, executed with a BootstrapParams. This lets callers customize the generated Envoy
+// config (tracing, stats sinks, extra listeners, ...) without forking this package.
+type TemplateBootstrapProvider struct {
+	// Source is the text/template source executed with a BootstrapParams.
+	Source string
+}
+
+// Render implements BootstrapProvider.
+func (p TemplateBootstrapProvider) Render(params BootstrapParams) ([]byte, error) {
+	t, err := template.New("bootstrap").Parse(p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing bootstrap template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("failed rendering bootstrap template: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// XDSBootstrapProvider renders a bootstrap config that points Envoy at a remote xDS/ADS server
+// (e.g. a real Pilot) rather than the static clusters StaticBootstrapProvider generates.
+type XDSBootstrapProvider struct {
+	// PilotAddress is the host:port of the xDS/ADS server Envoy should connect to.
+	PilotAddress string
+	// NodeID is the Envoy node ID reported to the xDS server. Defaults to the service name.
+	NodeID string
+}
+
+// Render implements BootstrapProvider.
+func (p XDSBootstrapProvider) Render(params BootstrapParams) ([]byte, error) {
+	if p.PilotAddress == "" {
+		return nil, fmt.Errorf("XDSBootstrapProvider: PilotAddress is required")
+	}
+	host, port, err := net.SplitHostPort(p.PilotAddress)
+	if err != nil {
+		return nil, fmt.Errorf("XDSBootstrapProvider: invalid PilotAddress %q: %v", p.PilotAddress, err)
+	}
+
+	nodeID := p.NodeID
+	if nodeID == "" {
+		nodeID = params.ServiceName
+	}
+
+	var buf bytes.Buffer
+	if err := xdsBootstrapTemplate.Execute(&buf, xdsBootstrapParams{
+		BootstrapParams: params,
+		NodeID:          nodeID,
+		PilotHost:       host,
+		PilotPort:       port,
+	}); err != nil {
+		return nil, fmt.Errorf("failed rendering xds bootstrap: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// xdsBootstrapParams extends BootstrapParams with the fields specific to XDSBootstrapProvider.
+type xdsBootstrapParams struct {
+	BootstrapParams
+	NodeID    string
+	PilotHost string
+	PilotPort string
+}
+
+var xdsBootstrapTemplate = template.Must(template.New("xds-bootstrap").Parse(`
+node:
+  id: {{ .NodeID }}
+  cluster: {{ .ServiceName }}
+admin:
+  address:
+    socket_address: { address: {{ .BindAddress }}, port_value: {{ .AdminPort }} }
+static_resources:
+  listeners:
+  - name: ready_listener
+    address:
+      socket_address: { address: {{ .BindAddress }}, port_value: {{ .ReadyPort }} }
+    filter_chains:
+    - filters:
+      - name: envoy.http_connection_manager
+        config:
+          stat_prefix: ready
+          route_config:
+            name: local_route
+            virtual_hosts:
+            - name: ready_service
+              domains: ["*"]
+              routes:
+              - match: { prefix: "/ready" }
+                route: { cluster: envoy_admin }
+          http_filters:
+          - name: envoy.router
+  clusters:
+  - name: envoy_admin
+    connect_timeout: 1s
+    type: STATIC
+    lb_policy: ROUND_ROBIN
+    load_assignment:
+      cluster_name: envoy_admin
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{ .BindAddress }}, port_value: {{ .AdminPort }} }
+  - name: xds_cluster
+    connect_timeout: 1s
+    type: STRICT_DNS
+    http2_protocol_options: {}
+    load_assignment:
+      cluster_name: xds_cluster
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{ .PilotHost }}, port_value: {{ .PilotPort }} }
+dynamic_resources:
+  ads_config:
+    api_type: GRPC
+    grpc_services:
+    - envoy_grpc:
+        cluster_name: xds_cluster
+  cds_config:
+    ads: {}
+  lds_config:
+    ads: {}
+`))
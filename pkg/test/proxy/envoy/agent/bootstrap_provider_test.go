@@ -0,0 +1,151 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// TestStaticBootstrapProviderFilterChains renders a single-port bootstrap for each supported
+// protocol and checks that the filter chain Envoy actually needs for that protocol is present --
+// and that protocol-specific bits (TLS, HTTP/2) don't leak onto protocols that don't want them.
+func TestStaticBootstrapProviderFilterChains(t *testing.T) {
+	cases := []struct {
+		name           string
+		protocol       model.Protocol
+		want           []string
+		wantHTTP2Count int
+	}{
+		{
+			name:     "http",
+			protocol: model.ProtocolHTTP,
+			want:     []string{"envoy.http_connection_manager"},
+		},
+		{
+			name:     "https",
+			protocol: model.ProtocolHTTPS,
+			want:     []string{"envoy.http_connection_manager", "transport_socket", `certificate_chain: { filename: "cert.pem" }`, `private_key: { filename: "key.pem" }`},
+		},
+		{
+			name:           "grpc",
+			protocol:       model.ProtocolGRPC,
+			want:           []string{"envoy.http_connection_manager"},
+			wantHTTP2Count: 2, // once for the listener's HCM, once for the upstream cluster
+		},
+		{
+			name:     "tcp",
+			protocol: model.ProtocolTCP,
+			want:     []string{"envoy.tcp_proxy"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			params := BootstrapParams{
+				ServiceName: "svc",
+				BindAddress: "127.0.0.1",
+				AdminPort:   1001,
+				ReadyPort:   1002,
+				TLSCert:     "cert.pem",
+				TLSCKey:     "key.pem",
+				Ports: []Port{
+					{
+						Config:      PortConfig{Name: "p", Protocol: c.protocol},
+						EnvoyPort:   2001,
+						ServicePort: 2002,
+						Address:     "127.0.0.1",
+					},
+				},
+			}
+
+			rendered, err := StaticBootstrapProvider{}.Render(params)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			out := string(rendered)
+
+			for _, want := range c.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("rendered bootstrap missing %q:\n%s", want, out)
+				}
+			}
+			if got := strings.Count(out, "http2_protocol_options: {}"); got != c.wantHTTP2Count {
+				t.Errorf("http2_protocol_options: {} count = %d, want %d:\n%s", got, c.wantHTTP2Count, out)
+			}
+		})
+	}
+}
+
+// TestStaticBootstrapProviderReadyListener checks that StaticBootstrapProvider always renders a
+// ready_listener bound to ReadyPort, proxying to an envoy_admin cluster bound to AdminPort --
+// distinct from any of the data-plane listeners/clusters for configured ports.
+func TestStaticBootstrapProviderReadyListener(t *testing.T) {
+	params := BootstrapParams{
+		ServiceName: "svc",
+		BindAddress: "127.0.0.1",
+		AdminPort:   1001,
+		ReadyPort:   1002,
+	}
+
+	rendered, err := StaticBootstrapProvider{}.Render(params)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	out := string(rendered)
+
+	for _, want := range []string{
+		"name: ready_listener",
+		"socket_address: { address: 127.0.0.1, port_value: 1002 }",
+		"name: envoy_admin",
+		"socket_address: { address: 127.0.0.1, port_value: 1001 }",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered bootstrap missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// TestXDSBootstrapProviderReadyListener checks that XDSBootstrapProvider renders the same static
+// ready_listener/envoy_admin cluster as StaticBootstrapProvider, so GetEnvoyReadyPort is honored
+// regardless of which provider an Agent is configured with.
+func TestXDSBootstrapProviderReadyListener(t *testing.T) {
+	params := BootstrapParams{
+		ServiceName: "svc",
+		BindAddress: "127.0.0.1",
+		AdminPort:   1001,
+		ReadyPort:   1002,
+	}
+
+	rendered, err := XDSBootstrapProvider{PilotAddress: "pilot.istio-system:15010"}.Render(params)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	out := string(rendered)
+
+	for _, want := range []string{
+		"name: ready_listener",
+		"socket_address: { address: 127.0.0.1, port_value: 1002 }",
+		"name: envoy_admin",
+		"socket_address: { address: 127.0.0.1, port_value: 1001 }",
+		"name: xds_cluster",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rendered bootstrap missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// envoyConfig is the rendered bootstrap configuration for a single Agent's Envoy instance.
+type envoyConfig struct {
+	configFile string
+}
+
+// dispose removes the on-disk bootstrap config file.
+func (c *envoyConfig) dispose() {
+	if c.configFile != "" {
+		_ = os.Remove(c.configFile)
+	}
+}
+
+// writeBootstrap writes rendered bootstrap contents to a temp file under tmpDir and returns
+// the resulting envoyConfig.
+func writeBootstrap(tmpDir, serviceName string, contents []byte) (*envoyConfig, error) {
+	f, err := ioutil.TempFile(tmpDir, fmt.Sprintf("envoy-%s-*.yaml", serviceName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(contents); err != nil {
+		return nil, err
+	}
+
+	return &envoyConfig{configFile: f.Name()}, nil
+}
+
+// StaticBootstrapProvider is the default BootstrapProvider. It renders a fully static Envoy
+// bootstrap: one listener and cluster per configured port, wired directly to the backend
+// service ports the Agent allocated, plus the admin and readiness listeners.
+type StaticBootstrapProvider struct{}
+
+// Render implements BootstrapProvider.
+func (StaticBootstrapProvider) Render(params BootstrapParams) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := envoyBootstrapTemplate.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("failed rendering envoy bootstrap: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// listenerName returns the name Envoy will use for the listener fronting the given port.
+func listenerName(p Port) string {
+	return fmt.Sprintf("%s-%s", p.Config.Name, p.Config.Protocol)
+}
+
+// clusterName returns the name Envoy will use for the upstream cluster backing the given port.
+func clusterName(p Port) string {
+	return fmt.Sprintf("%s-%s-local", p.Config.Name, p.Config.Protocol)
+}
+
+// filterChainFor renders the network filters for a port, based on its protocol: HTTP/HTTPS/gRPC
+// all get an HTTP connection manager (gRPC configured for h2), and TCP gets a tcp_proxy
+// passthrough. The TLS transport_socket that terminates ProtocolHTTPS is added separately by
+// envoyBootstrapTemplate, since it sits alongside the filters on the filter_chain rather than
+// inside it.
+func filterChainFor(p Port) (string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch p.Config.Protocol {
+	case model.ProtocolHTTP, model.ProtocolHTTPS, model.ProtocolGRPC:
+		err = httpFilterChainTemplate.Execute(&buf, p)
+	case model.ProtocolTCP:
+		err = tcpFilterChainTemplate.Execute(&buf, p)
+	default:
+		return "", fmt.Errorf("protocol %v not currently supported", p.Config.Protocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var envoyBootstrapTemplate = template.Must(template.New("bootstrap").Funcs(template.FuncMap{
+	"listenerName": listenerName,
+	"clusterName":  clusterName,
+	"filterChain":  filterChainFor,
+	"isGRPC":       func(p Port) bool { return p.Config.Protocol == model.ProtocolGRPC },
+	"isHTTPS":      func(p Port) bool { return p.Config.Protocol == model.ProtocolHTTPS },
+}).Parse(`
+admin:
+  address:
+    socket_address: { address: {{ .BindAddress }}, port_value: {{ .AdminPort }} }
+static_resources:
+  listeners:
+  - name: ready_listener
+    address:
+      socket_address: { address: {{ .BindAddress }}, port_value: {{ .ReadyPort }} }
+    filter_chains:
+    - filters:
+      - name: envoy.http_connection_manager
+        config:
+          stat_prefix: ready
+          route_config:
+            name: local_route
+            virtual_hosts:
+            - name: ready_service
+              domains: ["*"]
+              routes:
+              - match: { prefix: "/ready" }
+                route: { cluster: envoy_admin }
+          http_filters:
+          - name: envoy.router
+  {{- range .Ports }}
+  - name: {{ listenerName . }}
+    address:
+      socket_address: { address: {{ .Address }}, port_value: {{ .EnvoyPort }} }
+    filter_chains:
+    - filters:
+{{ filterChain . }}
+      {{- if isHTTPS . }}
+      transport_socket:
+        name: tls
+        config:
+          common_tls_context:
+            tls_certificates:
+            - certificate_chain: { filename: "{{ $.TLSCert }}" }
+              private_key: { filename: "{{ $.TLSCKey }}" }
+      {{- end }}
+  {{- end }}
+  clusters:
+  - name: envoy_admin
+    connect_timeout: 1s
+    type: STATIC
+    lb_policy: ROUND_ROBIN
+    load_assignment:
+      cluster_name: envoy_admin
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{ .BindAddress }}, port_value: {{ .AdminPort }} }
+  {{- range .Ports }}
+  - name: {{ clusterName . }}
+    connect_timeout: 1s
+    type: STATIC
+    lb_policy: ROUND_ROBIN
+    {{- if isGRPC . }}
+    http2_protocol_options: {}
+    {{- end }}
+    load_assignment:
+      cluster_name: {{ clusterName . }}
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: { address: {{ .Address }}, port_value: {{ .ServicePort }} }
+  {{- end }}
+`))
+
+var httpFilterChainTemplate = template.Must(template.New("http-filter-chain").Funcs(template.FuncMap{
+	"listenerName": listenerName,
+	"clusterName":  clusterName,
+	"isGRPC":       func(p Port) bool { return p.Config.Protocol == model.ProtocolGRPC },
+}).Parse(`
+    - name: envoy.http_connection_manager
+      config:
+        stat_prefix: {{ listenerName . }}
+        {{- if isGRPC . }}
+        http2_protocol_options: {}
+        {{- end }}
+        route_config:
+          name: local_route
+          virtual_hosts:
+          - name: local_service
+            domains: ["*"]
+            routes:
+            - match: { prefix: "/" }
+              route: { cluster: {{ clusterName . }} }
+        http_filters:
+        - name: envoy.router
+`))
+
+var tcpFilterChainTemplate = template.Must(template.New("tcp-filter-chain").Funcs(template.FuncMap{
+	"listenerName": listenerName,
+	"clusterName":  clusterName,
+}).Parse(`
+    - name: envoy.tcp_proxy
+      config:
+        stat_prefix: {{ listenerName . }}
+        cluster: {{ clusterName . }}
+`))
@@ -0,0 +1,82 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"net"
+)
+
+// defaultBindAddress is used whenever Config.BindAddress is left empty, preserving the
+// package's original IPv4-loopback-only behavior.
+const defaultBindAddress = "localhost"
+
+// resolveBindAddress defaults an empty bind address to localhost and validates that whatever
+// address is configured resolves to a loopback interface, so that Envoy/echo listeners started
+// by this package never end up reachable from outside the host running the test.
+func resolveBindAddress(bindAddress string) (string, error) {
+	if bindAddress == "" {
+		bindAddress = defaultBindAddress
+	}
+
+	ips, err := net.LookupIP(bindAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed resolving bind address %q: %v", bindAddress, err)
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return bindAddress, nil
+		}
+	}
+	return "", fmt.Errorf("bind address %q does not resolve to a loopback interface", bindAddress)
+}
+
+// portAllocator is the package-level allocator shared by every Agent, so that two Agents
+// started concurrently in the same process never hand out the same port.
+var portAllocator = &PortAllocator{}
+
+// ReservedPort is a free TCP port whose listening socket is held open until Release is called,
+// so that nothing else can bind it out from under the caller in the meantime.
+type ReservedPort struct {
+	Port     int
+	listener *net.TCPListener
+}
+
+// Release closes the underlying listener, freeing the port for its intended consumer (or
+// anything else) to bind.
+func (p *ReservedPort) Release() error {
+	return p.listener.Close()
+}
+
+// PortAllocator reserves local ports by holding their listening sockets open until released.
+type PortAllocator struct{}
+
+// Reserve finds a free TCP port on bindAddress and holds it open until Release is called.
+func (a *PortAllocator) Reserve(bindAddress string) (*ReservedPort, error) {
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(bindAddress, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReservedPort{
+		Port:     l.Addr().(*net.TCPAddr).Port,
+		listener: l,
+	}, nil
+}
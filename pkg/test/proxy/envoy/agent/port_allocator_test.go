@@ -0,0 +1,104 @@
+//  Copyright 2018 Istio Authors
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package agent
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestPortAllocatorConcurrentReserveNoCollisions spins up N concurrent "agents", each reserving
+// the same number of ports createPorts() would (admin, ready, and one per service port), and
+// verifies that every reserved port across all of them is unique. This is the scenario
+// findFreePort's close-then-rebind race used to flake under.
+func TestPortAllocatorConcurrentReserveNoCollisions(t *testing.T) {
+	const numAgents = 20
+	const portsPerAgent = 5
+
+	a := &PortAllocator{}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved []*ReservedPort
+		errs     []error
+	)
+	for i := 0; i < numAgents; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < portsPerAgent; j++ {
+				p, err := a.Reserve(defaultBindAddress)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					reserved = append(reserved, p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	defer func() {
+		for _, p := range reserved {
+			_ = p.Release()
+		}
+	}()
+
+	for _, err := range errs {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+
+	seen := make(map[int]bool, len(reserved))
+	for _, p := range reserved {
+		if seen[p.Port] {
+			t.Fatalf("port %d was reserved more than once", p.Port)
+		}
+		seen[p.Port] = true
+	}
+	if len(seen) != numAgents*portsPerAgent {
+		t.Fatalf("got %d unique ports, want %d", len(seen), numAgents*portsPerAgent)
+	}
+}
+
+// TestResolveBindAddress checks that resolveBindAddress accepts loopback addresses (defaulting
+// an empty one to localhost) and rejects anything that doesn't resolve to loopback.
+func TestResolveBindAddress(t *testing.T) {
+	cases := []struct {
+		name     string
+		bindAddr string
+		wantErr  bool
+	}{
+		{name: "empty defaults to localhost", bindAddr: ""},
+		{name: "ipv4 loopback", bindAddr: "127.0.0.1"},
+		{name: "ipv6 loopback", bindAddr: "::1"},
+		{name: "non-loopback ipv4 rejected", bindAddr: "8.8.8.8", wantErr: true},
+		{name: "unresolvable host rejected", bindAddr: "not-a-real-host.invalid", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := resolveBindAddress(c.bindAddr)
+			if c.wantErr && err == nil {
+				t.Fatalf("resolveBindAddress(%q) = nil error, want error", c.bindAddr)
+			}
+			if !c.wantErr && err != nil {
+				t.Fatalf("resolveBindAddress(%q) = %v, want no error", c.bindAddr, err)
+			}
+		})
+	}
+}